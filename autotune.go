@@ -0,0 +1,253 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// TuningRule selects the closed-loop rule set used to turn the ultimate
+// gain and period measured by AutoTune into PID gains.
+type TuningRule int
+
+const (
+	// ZieglerNicholsPID is the classic Ziegler-Nichols closed-loop rule.
+	// It tends to be aggressive, with roughly quarter-amplitude decay.
+	ZieglerNicholsPID TuningRule = iota
+	// TyreusLuyben trades some responsiveness for robustness and is a
+	// reasonable default for plants that cannot tolerate overshoot.
+	TyreusLuyben
+)
+
+// DefaultRelayAmplitude contains the default relay output amplitude d used by AutoTune.
+var DefaultRelayAmplitude = 1.0
+
+// DefaultRelayHysteresis contains the default dead band around Target used to reject measurement noise.
+var DefaultRelayHysteresis = 0.0
+
+// DefaultRelayCycles contains the default number of successive stable oscillations required before AutoTune returns.
+var DefaultRelayCycles = 5
+
+// DefaultRelayTolerance contains the default fractional amplitude variation allowed between successive oscillations for them to be considered stable.
+var DefaultRelayTolerance = 0.1
+
+// DefaultRelaySamplePeriod contains the default interval at which the plant is sampled and driven while relay feedback is active.
+var DefaultRelaySamplePeriod = 50 * time.Millisecond
+
+// ErrRelayTimeout is returned by AutoTune when ctx is done before a stable oscillation is found.
+var ErrRelayTimeout = errors.New("control: relay feedback did not converge before context was done")
+
+// RelayConfig holds the tunable parameters of the relay-feedback experiment performed by AutoTune.
+// Zero values fall back to the package defaults.
+type RelayConfig struct {
+	// Amplitude is the relay output magnitude d. Uses DefaultRelayAmplitude if 0.
+	Amplitude float64
+	// Hysteresis is the dead band around Target that the error must cross before the relay switches. Uses DefaultRelayHysteresis if 0.
+	Hysteresis float64
+	// Cycles is the number of successive stable oscillations required before returning. Uses DefaultRelayCycles if 0.
+	Cycles int
+	// Tolerance is the fractional amplitude variation allowed across the Cycles window. Uses DefaultRelayTolerance if 0.
+	Tolerance float64
+	// SamplePeriod is how often the plant is driven and measured. Uses DefaultRelaySamplePeriod if 0.
+	SamplePeriod time.Duration
+	// Rule selects the closed-loop rule applied to (Ku, Tu).
+	Rule TuningRule
+}
+
+// relayDriven is implemented by plants that accept the relay's control input. AutoTune
+// requires it: a plant that only implements Task has no way to receive the relay's +d/-d
+// output, so it would never actually oscillate and measure would just reflect whatever the
+// plant does on its own until ctx ran out.
+type relayDriven interface {
+	SetOutput(output float64)
+}
+
+// ErrPlantNotRelayDriven is returned by AutoTune when plant does not implement
+// SetOutput(float64) (see relayDriven), since such a plant can never be driven by the relay
+// and the experiment would otherwise just run until ctx is done with no useful signal.
+var ErrPlantNotRelayDriven = errors.New("control: AutoTune requires a plant implementing SetOutput(float64)")
+
+// AutoTune performs an Astrom-Hagglund relay-feedback experiment against plant.
+// It temporarily drives plant with a bang-bang relay of amplitude d around target:
+// the relay output is +d while measure() is below target (minus Hysteresis) and -d
+// while it is above (plus Hysteresis). The plant is sampled and driven every
+// cfg.SamplePeriod. AutoTune measures the sustained oscillation period Tu (the mean
+// period between relay switches of the same direction) and its amplitude a (the mean
+// half peak-to-peak swing of measure()), computes the ultimate gain Ku = 4d/(pi*a),
+// and applies cfg.Rule to derive Kp, Ki and Kd.
+//
+// plant must implement SetOutput(float64) so the relay can actually drive it; AutoTune
+// returns ErrPlantNotRelayDriven immediately if it doesn't. Implementing Task alone is not
+// enough.
+//
+// AutoTune requires cfg.Cycles successive oscillations whose amplitudes are within
+// cfg.Tolerance of their mean before it accepts the measurement as converged. It
+// returns ErrRelayTimeout if ctx is done first.
+func AutoTune(ctx context.Context, plant Task, measure func() float64, target float64, cfg RelayConfig) (Kp, Ki, Kd float64, err error) {
+	if plant == nil {
+		return 0, 0, 0, errors.New("control: AutoTune requires a plant")
+	}
+	if measure == nil {
+		return 0, 0, 0, errors.New("control: AutoTune requires a measure function")
+	}
+	driver, ok := plant.(relayDriven)
+	if !ok {
+		return 0, 0, 0, ErrPlantNotRelayDriven
+	}
+
+	amplitude := cfg.Amplitude
+	if amplitude == 0 {
+		amplitude = DefaultRelayAmplitude
+	}
+	hysteresis := cfg.Hysteresis
+	if hysteresis == 0 {
+		hysteresis = DefaultRelayHysteresis
+	}
+	cycles := cfg.Cycles
+	if cycles == 0 {
+		cycles = DefaultRelayCycles
+	}
+	tolerance := cfg.Tolerance
+	if tolerance == 0 {
+		tolerance = DefaultRelayTolerance
+	}
+	period := cfg.SamplePeriod
+	if period == 0 {
+		period = DefaultRelaySamplePeriod
+	}
+
+	high := true
+	haveExtreme := false
+	var lo, hi float64
+
+	var switchTimes []time.Time
+	var halfAmplitudes []float64
+
+	var periods []time.Duration
+	var periodAmplitudes []float64
+
+	drive := func() {
+		output := -amplitude
+		if high {
+			output = amplitude
+		}
+		driver.SetOutput(output)
+		plant.Do()
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	// prime the relay with an initial sample so the first half-cycle has a baseline.
+	drive()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, 0, 0, ErrRelayTimeout
+		case <-ticker.C:
+		}
+
+		value := measure()
+		if !haveExtreme {
+			lo, hi = value, value
+			haveExtreme = true
+		} else {
+			lo = math.Min(lo, value)
+			hi = math.Max(hi, value)
+		}
+
+		delta := target - value
+		wasHigh := high
+		switch {
+		case delta > hysteresis:
+			high = true
+		case delta < -hysteresis:
+			high = false
+		}
+
+		if high != wasHigh {
+			now := time.Now()
+			switchTimes = append(switchTimes, now)
+			halfAmplitudes = append(halfAmplitudes, (hi-lo)/2)
+			lo, hi = value, value
+
+			// two switches make a full period (e.g. low->high->low).
+			if n := len(switchTimes); n >= 3 {
+				tu := switchTimes[n-1].Sub(switchTimes[n-3])
+				a := (halfAmplitudes[n-2] + halfAmplitudes[n-1]) / 2
+				periods = append(periods, tu)
+				periodAmplitudes = append(periodAmplitudes, a)
+
+				if stable(periodAmplitudes, cycles, tolerance) {
+					tuMean, aMean := meanWindow(periods, periodAmplitudes, cycles)
+					if aMean <= 0 {
+						return 0, 0, 0, errors.New("control: relay feedback produced no measurable oscillation")
+					}
+					ku := 4 * amplitude / (math.Pi * aMean)
+					Kp, Ki, Kd = cfg.Rule.gains(ku, tuMean)
+					return Kp, Ki, Kd, nil
+				}
+			}
+		}
+
+		drive()
+	}
+}
+
+// stable reports whether the last n entries of amplitudes are all within tolerance of their mean.
+func stable(amplitudes []float64, n int, tolerance float64) bool {
+	if len(amplitudes) < n {
+		return false
+	}
+	window := amplitudes[len(amplitudes)-n:]
+	mean := 0.0
+	for _, a := range window {
+		mean += a
+	}
+	mean /= float64(len(window))
+	if mean <= 0 {
+		return false
+	}
+	for _, a := range window {
+		if math.Abs(a-mean)/mean > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// meanWindow returns the mean period and amplitude over the last n entries.
+func meanWindow(periods []time.Duration, amplitudes []float64, n int) (tu time.Duration, a float64) {
+	pw := periods[len(periods)-n:]
+	aw := amplitudes[len(amplitudes)-n:]
+	var sum time.Duration
+	for _, p := range pw {
+		sum += p
+	}
+	tu = sum / time.Duration(len(pw))
+	for _, x := range aw {
+		a += x
+	}
+	a /= float64(len(aw))
+	return
+}
+
+// gains applies the closed-loop rule to the ultimate gain Ku and ultimate period Tu.
+func (rule TuningRule) gains(ku float64, tu time.Duration) (Kp, Ki, Kd float64) {
+	t := tu.Seconds()
+	switch rule {
+	case TyreusLuyben:
+		Kp = ku / 3.2
+		Ki = Kp / (2.2 * t)
+		Kd = Kp * t / 6.3
+	default: // ZieglerNicholsPID
+		Kp = 0.6 * ku
+		Ki = 1.2 * ku / t
+		Kd = 0.075 * ku * t
+	}
+	return
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+// delayPlant is a pure transport-delay plant: whatever relay output it's driven with comes back
+// out of Measure() delaySteps calls to Do() later, with no attenuation or noise. Under relay
+// feedback with hysteresis 0, it produces a clean square wave of half-amplitude exactly
+// Amplitude and period 2*(delaySteps+1) samples (AutoTune measures before it drives), so AutoTune's ultimate gain should converge to the
+// textbook Ku = 4*Amplitude/(pi*Amplitude) = 4/pi regardless of Amplitude.
+type delayPlant struct {
+	buf    []float64
+	idx    int
+	output float64
+	value  float64
+}
+
+func newDelayPlant(delaySteps int) *delayPlant {
+	return &delayPlant{buf: make([]float64, delaySteps)}
+}
+
+func (p *delayPlant) SetOutput(output float64) { p.output = output }
+
+func (p *delayPlant) Do() {
+	next := p.buf[p.idx%len(p.buf)]
+	p.buf[p.idx%len(p.buf)] = p.output
+	p.value = next
+	p.idx++
+}
+
+func (p *delayPlant) Measure() float64 { return p.value }
+
+func TestAutoTuneConverges(t *testing.T) {
+	plant := newDelayPlant(3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cfg := RelayConfig{
+		Amplitude:    1,
+		Cycles:       3,
+		Tolerance:    0.3,
+		SamplePeriod: 5 * time.Millisecond,
+	}
+
+	Kp, Ki, Kd, err := AutoTune(ctx, plant, plant.Measure, 0, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if Kp <= 0 || Ki <= 0 || Kd <= 0 {
+		t.Fatalf("expected all positive gains, got Kp=%f Ki=%f Kd=%f", Kp, Ki, Kd)
+	}
+
+	// recover Ku from Kp = 0.6*Ku (the ZieglerNicholsPID rule) and check it's close to the
+	// textbook 4/pi this plant should produce.
+	ku := Kp / 0.6
+	wantKu := 4 / math.Pi
+	if math.Abs(ku-wantKu)/wantKu > 0.3 {
+		t.Fatalf("expected Ku close to %f, derived %f from gains Kp=%f Ki=%f Kd=%f", wantKu, ku, Kp, Ki, Kd)
+	}
+}
+
+func TestAutoTuneTyreusLuyben(t *testing.T) {
+	plant := newDelayPlant(3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cfg := RelayConfig{
+		Amplitude:    1,
+		Cycles:       3,
+		Tolerance:    0.3,
+		SamplePeriod: 5 * time.Millisecond,
+		Rule:         TyreusLuyben,
+	}
+
+	Kp, Ki, Kd, err := AutoTune(ctx, plant, plant.Measure, 0, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if Kp <= 0 || Ki <= 0 || Kd <= 0 {
+		t.Fatalf("expected all positive gains, got Kp=%f Ki=%f Kd=%f", Kp, Ki, Kd)
+	}
+}
+
+func TestAutoTuneRequiresPlantAndMeasure(t *testing.T) {
+	measure := func() float64 { return 0 }
+
+	if _, _, _, err := AutoTune(context.Background(), nil, measure, 0, RelayConfig{}); err == nil {
+		t.Fatal("expected an error for a nil plant")
+	}
+
+	plant := newDelayPlant(3)
+	if _, _, _, err := AutoTune(context.Background(), plant, nil, 0, RelayConfig{}); err == nil {
+		t.Fatal("expected an error for a nil measure function")
+	}
+}
+
+// taskOnlyPlant implements Task but not relayDriven, the mistake AutoTune's doc comment now
+// warns against: a caller who forgets SetOutput would otherwise see the relay never switch.
+type taskOnlyPlant struct{}
+
+func (taskOnlyPlant) Do() {}
+
+func TestAutoTuneRequiresRelayDrivenPlant(t *testing.T) {
+	measure := func() float64 { return 0 }
+
+	_, _, _, err := AutoTune(context.Background(), taskOnlyPlant{}, measure, 0, RelayConfig{})
+	if err != ErrPlantNotRelayDriven {
+		t.Fatalf("expected ErrPlantNotRelayDriven, got %v", err)
+	}
+}
+
+func TestAutoTuneTimeout(t *testing.T) {
+	plant := newDelayPlant(3)
+	measure := func() float64 { return 0 } // always equal to target: the relay never switches
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, _, err := AutoTune(ctx, plant, measure, 0, RelayConfig{SamplePeriod: 5 * time.Millisecond})
+	if err != ErrRelayTimeout {
+		t.Fatalf("expected ErrRelayTimeout, got %v", err)
+	}
+}
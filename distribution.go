@@ -0,0 +1,102 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Distribution generates successive inter-arrival times around a target mean, letting
+// Generator reproduce the think-time models used by workload generators such as pgcheetah
+// instead of spacing every request exactly target apart.
+type Distribution interface {
+	// NextInterval returns the wait to apply before the next request, distributed around target.
+	NextInterval(target time.Duration) time.Duration
+}
+
+// Constant reproduces the original Generator behavior: every interval equals target exactly.
+type Constant struct{}
+
+// NextInterval returns target unchanged.
+func (Constant) NextInterval(target time.Duration) time.Duration {
+	return target
+}
+
+// Uniform distributes intervals uniformly within [target+Min, target+Max], so that the mean
+// interval stays at target as long as Min and Max are symmetric (the usual case).
+type Uniform struct {
+	Min, Max time.Duration
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NextInterval returns a value drawn uniformly from [target+Min, target+Max], floored at 0.
+func (u *Uniform) NextInterval(target time.Duration) time.Duration {
+	if u.Max <= u.Min {
+		return target
+	}
+	u.mu.Lock()
+	if u.rand == nil {
+		u.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	span := float64(u.Max - u.Min)
+	v := float64(target+u.Min) + u.rand.Float64()*span
+	u.mu.Unlock()
+	if v < 0 {
+		v = 0
+	}
+	return time.Duration(v)
+}
+
+// Exponential produces Poisson arrivals: intervals drawn from an exponential distribution
+// with mean target, i.e. -ln(U)/lambda with lambda = 1/target.
+type Exponential struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NextInterval returns -ln(U)/lambda with lambda = 1/target, so the mean interval equals target.
+func (e *Exponential) NextInterval(target time.Duration) time.Duration {
+	if target <= 0 {
+		return target
+	}
+	e.mu.Lock()
+	if e.rand == nil {
+		e.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	u := e.rand.Float64()
+	e.mu.Unlock()
+	// avoid log(0)
+	for u == 0 {
+		u = 1e-9
+	}
+	return time.Duration(-math.Log(u) * float64(target))
+}
+
+// Normal distributes intervals around target+Mean with standard deviation StdDev, clamped to
+// be non-negative. Mean defaults to 0, i.e. the distribution is centered on target.
+type Normal struct {
+	Mean   time.Duration
+	StdDev time.Duration
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NextInterval returns a value drawn from Normal(target+Mean, StdDev), floored at 0.
+func (n *Normal) NextInterval(target time.Duration) time.Duration {
+	n.mu.Lock()
+	if n.rand == nil {
+		n.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	v := n.rand.NormFloat64()*float64(n.StdDev) + float64(target+n.Mean)
+	n.mu.Unlock()
+	if v < 0 {
+		v = 0
+	}
+	return time.Duration(v)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testDistributionQPS(t *testing.T, name string, dist Distribution) {
+	var count int32
+
+	qps := 200.0
+	cycles := 2.0
+
+	results := make(chan time.Time)
+	h := func() {
+		c := atomic.AddInt32(&count, 1)
+		if c == int32(qps*cycles) {
+			results <- time.Now()
+		}
+	}
+
+	g := Generator{
+		QPS:          qps,
+		Handler:      TaskFunc(h),
+		Interarrival: dist,
+	}
+
+	t0 := time.Now()
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error starting generator: %v", err)
+	}
+	defer g.Stop()
+	result := <-results
+
+	dt := result.Sub(t0).Seconds()
+	observed := qps * cycles / dt
+
+	if math.Abs(qps-observed) > qps*0.2 {
+		t.Fatalf("%s: was expecting qps=%f after %f cycles and got qps=%f", name, qps, cycles, observed)
+	}
+}
+
+func TestGeneratorDistributions(t *testing.T) {
+	t.Run("Constant", func(t *testing.T) { testDistributionQPS(t, "Constant", Constant{}) })
+	t.Run("Uniform", func(t *testing.T) {
+		testDistributionQPS(t, "Uniform", &Uniform{Min: -1 * time.Millisecond, Max: 1 * time.Millisecond})
+	})
+	t.Run("Exponential", func(t *testing.T) { testDistributionQPS(t, "Exponential", &Exponential{}) })
+	t.Run("Normal", func(t *testing.T) {
+		testDistributionQPS(t, "Normal", &Normal{StdDev: 500 * time.Microsecond})
+	})
+}
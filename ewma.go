@@ -0,0 +1,152 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ewmaTickInterval is the interval at which EWMA.Tick is expected to be called by Meter,
+// matching the convention used by rcrowley/go-metrics.
+const ewmaTickInterval = 5 * time.Second
+
+// EWMA computes an exponentially-weighted moving average of a rate, following the
+// same decaying model as the Unix load average (and rcrowley/go-metrics' Meter).
+type EWMA struct {
+	mu          sync.Mutex
+	alpha       float64
+	rate        float64
+	uncounted   int64
+	initialized bool
+}
+
+// NewEWMA returns an EWMA with the given smoothing constant alpha, to be ticked every ewmaTickInterval.
+func NewEWMA(alpha float64) *EWMA {
+	return &EWMA{alpha: alpha}
+}
+
+// NewEWMA1 returns an EWMA with a one-minute decay window.
+func NewEWMA1() *EWMA { return NewEWMA(1 - math.Exp(-5.0/60.0/1)) }
+
+// NewEWMA5 returns an EWMA with a five-minute decay window.
+func NewEWMA5() *EWMA { return NewEWMA(1 - math.Exp(-5.0/60.0/5)) }
+
+// NewEWMA15 returns an EWMA with a fifteen-minute decay window.
+func NewEWMA15() *EWMA { return NewEWMA(1 - math.Exp(-5.0/60.0/15)) }
+
+// Update adds n events observed since the last Tick.
+func (e *EWMA) Update(n int64) {
+	e.mu.Lock()
+	e.uncounted += n
+	e.mu.Unlock()
+}
+
+// Tick decays the moving average by one ewmaTickInterval. It should be called
+// at a steady ewmaTickInterval cadence, typically by a Meter.
+func (e *EWMA) Tick() {
+	e.mu.Lock()
+	count := e.uncounted
+	e.uncounted = 0
+	instantRate := float64(count) / ewmaTickInterval.Seconds()
+	if e.initialized {
+		e.rate += e.alpha * (instantRate - e.rate)
+	} else {
+		e.rate = instantRate
+		e.initialized = true
+	}
+	e.mu.Unlock()
+}
+
+// Rate returns the moving average rate of events per second.
+func (e *EWMA) Rate() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rate
+}
+
+// Meter tracks the mean rate of events plus their 1-, 5- and 15-minute EWMAs,
+// in the spirit of rcrowley/go-metrics' Meter.
+type Meter struct {
+	mu        sync.Mutex
+	count     int64
+	start     time.Time
+	ewma1     *EWMA
+	ewma5     *EWMA
+	ewma15    *EWMA
+	ticker    *time.Ticker
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewMeter returns a Meter and starts the background goroutine that ticks its EWMAs.
+func NewMeter() *Meter {
+	m := &Meter{
+		start:  time.Now(),
+		ewma1:  NewEWMA1(),
+		ewma5:  NewEWMA5(),
+		ewma15: NewEWMA15(),
+		ticker: time.NewTicker(ewmaTickInterval),
+		done:   make(chan struct{}),
+	}
+	go m.tick()
+	return m
+}
+
+func (m *Meter) tick() {
+	for {
+		select {
+		case <-m.ticker.C:
+			m.ewma1.Tick()
+			m.ewma5.Tick()
+			m.ewma15.Tick()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Mark records n events.
+func (m *Meter) Mark(n int64) {
+	m.mu.Lock()
+	m.count += n
+	m.mu.Unlock()
+	m.ewma1.Update(n)
+	m.ewma5.Update(n)
+	m.ewma15.Update(n)
+}
+
+// Count returns the total number of events recorded.
+func (m *Meter) Count() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.count
+}
+
+// Rate1 returns the one-minute moving average rate of events per second.
+func (m *Meter) Rate1() float64 { return m.ewma1.Rate() }
+
+// Rate5 returns the five-minute moving average rate of events per second.
+func (m *Meter) Rate5() float64 { return m.ewma5.Rate() }
+
+// Rate15 returns the fifteen-minute moving average rate of events per second.
+func (m *Meter) Rate15() float64 { return m.ewma15.Rate() }
+
+// RateMean returns the mean rate of events per second since the Meter was created.
+func (m *Meter) RateMean() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elapsed := time.Since(m.start).Seconds(); elapsed > 0 {
+		return float64(m.count) / elapsed
+	}
+	return 0
+}
+
+// Stop releases the goroutine backing the Meter's EWMAs. A stopped Meter no longer decays.
+func (m *Meter) Stop() {
+	m.closeOnce.Do(func() {
+		m.ticker.Stop()
+		close(m.done)
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import "testing"
+
+// TestEWMADecayRate checks the one-minute EWMA's first two ticks: fed 5 events/sec for one
+// ewmaTickInterval (Update(5*5), since Update counts events per tick, not a rate), the first
+// Tick sets the rate directly to 5, and a following tick with no events decays it toward 0.
+func TestEWMADecayRate(t *testing.T) {
+	e := NewEWMA1()
+
+	eventsPerTick := int64(5 * ewmaTickInterval.Seconds())
+	e.Update(eventsPerTick)
+	e.Tick()
+
+	if got := e.Rate(); got != 5 {
+		t.Fatalf("expected the first Tick to set the rate directly to 5, got %f", got)
+	}
+
+	// a quiet tick (no Update) should decay the rate toward 0, not hold or reset it.
+	e.Tick()
+	if got := e.Rate(); got <= 0 || got >= 5 {
+		t.Fatalf("expected the rate to have decayed strictly between 0 and 5, got %f", got)
+	}
+}
+
+func TestEWMAConvergesToSteadyRate(t *testing.T) {
+	e := NewEWMA1()
+
+	eventsPerTick := int64(10 * ewmaTickInterval.Seconds())
+	for i := 0; i < 50; i++ {
+		e.Update(eventsPerTick)
+		e.Tick()
+	}
+
+	if got := e.Rate(); got < 9.9 || got > 10.1 {
+		t.Fatalf("expected the rate to have converged to ~10, got %f", got)
+	}
+}
+
+func TestMeterMarkAndStop(t *testing.T) {
+	m := NewMeter()
+	defer m.Stop()
+
+	m.Mark(5)
+	m.Mark(3)
+
+	if got := m.Count(); got != 8 {
+		t.Fatalf("expected count 8, got %d", got)
+	}
+}
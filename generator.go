@@ -3,6 +3,8 @@
 package control
 
 import (
+	"context"
+	"errors"
 	"math"
 	"sync"
 	"time"
@@ -30,6 +32,23 @@ var DefaultGeneratorSleepPrecision = 50 * time.Millisecond
 // DefaultGeneratorSamplingPeriod defines the default approximate duration over which the QPS is sampled.
 var DefaultGeneratorSamplingPeriod = time.Second
 
+// ErrGeneratorNoHandler is returned by Start when Generator.Handler is nil.
+var ErrGeneratorNoHandler = errors.New("control: generator requires an handler")
+
+// Snapshot captures the Generator's measurements as last observed before it stopped.
+type Snapshot struct {
+	// QPS contains the number of calls being made per second.
+	QPS float64
+	// R contains the estimated time taken by the handler, in seconds.
+	R float64
+	// B contains the number of concurrent batches.
+	B int
+	// N contains the number of requests performed per batch.
+	N int
+	// W contains the wait, in seconds, applied between requests within a batch.
+	W float64
+}
+
 // Generator adapts itself to call an handler a fixed number of times per second.
 // It operates by creating B concurrent batches that will call the handler N times repeatedly.
 // After each call to the handler, it waits W seconds to space requests evently over time.
@@ -37,9 +56,13 @@ var DefaultGeneratorSamplingPeriod = time.Second
 // Note that the system adjusts B and W based on its estimation of the time R taken by the handler.
 // Therefore, if the variability of R is high, it may make it harder for the system to stabilize to a steady state.
 type Generator struct {
-	// Handler contains what gets executed periodically.
+	// Handler contains what gets executed periodically. Safe to set before Start; once the
+	// Generator is running, swap it via SetHandler instead of assigning this field directly,
+	// since the run loop reads it concurrently.
 	Handler Task
-	// QPS contains the number of calls that will be done per second.
+	// QPS contains the number of calls that will be done per second. Safe to set before Start;
+	// once the Generator is running, adjust it via SetQPS instead of assigning this field
+	// directly, since the run loop reads it concurrently.
 	QPS float64
 	// SmoothingFactor contains the weight of the exponential moving average that estimates the time taken by the handler.
 	// Will use DefaultGeneratorSmoothingFactor if 0.
@@ -50,15 +73,106 @@ type Generator struct {
 	// SamplingPeriod contains the approximate duration over which the QPS is sampled.
 	// Will use DefaultGeneratorSamplingPeriod if 0.
 	SamplingPeriod time.Duration
+	// Metrics, if set, receives request counts, achieved QPS and handler latency as each batch
+	// completes, and the current B/N/W and pool depth on every sampling tick.
+	Metrics *Registry
+	// Interarrival selects how the wait between requests within a batch is distributed
+	// around the mean wait W computed by evaluate. Will use Constant{} (the original,
+	// evenly-spaced behavior) if nil.
+	Interarrival Distribution
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	last   Snapshot
+
+	fieldsMu sync.RWMutex
+}
+
+// SetHandler safely swaps Handler while the Generator may be running concurrently, e.g. from a
+// LatencyController wrapping it to time requests.
+func (generator *Generator) SetHandler(handler Task) {
+	generator.fieldsMu.Lock()
+	generator.Handler = handler
+	generator.fieldsMu.Unlock()
+}
+
+func (generator *Generator) getHandler() Task {
+	generator.fieldsMu.RLock()
+	defer generator.fieldsMu.RUnlock()
+	return generator.Handler
+}
+
+// SetQPS safely updates QPS while the Generator may be running concurrently, e.g. from a
+// LatencyController or LatencyController-style feedback loop adjusting the manipulated variable.
+func (generator *Generator) SetQPS(qps float64) {
+	generator.fieldsMu.Lock()
+	generator.QPS = qps
+	generator.fieldsMu.Unlock()
+}
+
+func (generator *Generator) getQPS() float64 {
+	generator.fieldsMu.RLock()
+	defer generator.fieldsMu.RUnlock()
+	return generator.QPS
+}
+
+// Start begins calling the handler at the requested frequency. The goroutines it spawns (the
+// run loop, its ticker and every pooled batch worker) run until ctx is done or Stop is called;
+// either drains in-flight batches and releases them. Start returns ErrGeneratorNoHandler
+// immediately if no Handler is set, or ctx.Err() if ctx is already done.
+func (generator *Generator) Start(ctx context.Context) error {
+	if generator.getHandler() == nil {
+		return ErrGeneratorNoHandler
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	generator.mu.Lock()
+	generator.cancel = cancel
+	generator.done = make(chan struct{})
+	generator.mu.Unlock()
+
+	go generator.run(ctx)
+	return nil
+}
+
+// Stop cancels the Generator's context, causing it to drain in-flight batches and exit. It
+// returns a channel that is closed once that drain completes and Wait would return immediately.
+func (generator *Generator) Stop() <-chan struct{} {
+	generator.mu.Lock()
+	cancel := generator.cancel
+	done := generator.done
+	generator.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if done == nil {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return done
 }
 
-// Start begins calling the handler at the requested frequency.
-func (generator *Generator) Start() {
-	if generator.Handler == nil {
-		panic("generator requires an handler")
+// Wait blocks until the Generator has stopped (via Stop or its context being done) and returns
+// the last observed Snapshot.
+func (generator *Generator) Wait() Snapshot {
+	generator.mu.Lock()
+	done := generator.done
+	generator.mu.Unlock()
+
+	if done != nil {
+		<-done
 	}
 
-	go generator.run()
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+	return generator.last
 }
 
 func (generator *Generator) evaluate(r float64) (b int, n int, w float64) {
@@ -74,7 +188,7 @@ func (generator *Generator) evaluate(r float64) (b int, n int, w float64) {
 	// thus, because QPS=B/(R+W)
 	// we end up with both W=B/QPS-R and N=S/(R+W)
 
-	qps := generator.QPS
+	qps := generator.getQPS()
 	if r > 0 {
 		b = int(math.Ceil(qps * r))
 	} else {
@@ -86,33 +200,62 @@ func (generator *Generator) evaluate(r float64) (b int, n int, w float64) {
 	return
 }
 
-func (generator *Generator) run() {
+func (generator *Generator) run(ctx context.Context) {
 	precision := generator.SleepPrecision
 	if precision == 0 {
 		precision = DefaultGeneratorSleepPrecision
 	}
 
 	type batch struct {
-		requests int
-		duration time.Duration
-		passback interface{}
+		requests    int
+		duration    time.Duration
+		passback    interface{}
+		calibration bool
+		handlerTime time.Duration
 	}
 
 	results := make(chan batch)
 
+	var workersMu sync.Mutex
+	var workers []chan batch
+
 	pool := sync.Pool{
 		New: func() interface{} {
 			batches := make(chan batch)
 
+			workersMu.Lock()
+			workers = append(workers, batches)
+			workersMu.Unlock()
+
 			// starts a batch that executes tasks and waits N times
 			go func() {
 				for item := range batches {
+					// the calibration probe exists purely to measure handler time R and must
+					// not be perturbed by Interarrival: a single noisy sample at target=0
+					// would otherwise get added straight into the R estimate and, through
+					// b = ceil(qps*r), cause a massive overshoot of B until the next tick.
+					var dist Distribution = Constant{}
+					if !item.calibration {
+						if generator.Interarrival != nil {
+							dist = generator.Interarrival
+						}
+					}
+
 					t0 := time.Now()
 					dt := time.Duration(0)
 
+				requests:
 					for i := 0; i != item.requests; i++ {
-						generator.Handler.Do()
-						if dt += item.duration; dt >= precision {
+						select {
+						case <-ctx.Done():
+							break requests
+						default:
+						}
+
+						h0 := time.Now()
+						generator.getHandler().Do()
+						item.handlerTime += time.Since(h0)
+						if dt += dist.NextInterval(item.duration); dt >= precision {
 							time.Sleep(dt)
 							dt = 0
 						}
@@ -128,12 +271,30 @@ func (generator *Generator) run() {
 		},
 	}
 
+	stop := func(last Snapshot) {
+		for _, w := range workers {
+			close(w)
+		}
+		close(results)
+
+		if generator.Metrics != nil {
+			generator.Metrics.Close()
+		}
+
+		generator.mu.Lock()
+		generator.last = last
+		done := generator.done
+		generator.mu.Unlock()
+		close(done)
+	}
+
 	// perform a quick approximation with a first batch of 1 request
 	queue := pool.Get().(chan batch)
 	queue <- batch{
-		requests: 1,
-		duration: 0,
-		passback: queue,
+		requests:    1,
+		duration:    0,
+		passback:    queue,
+		calibration: true,
 	}
 
 	first := <-results
@@ -145,17 +306,24 @@ func (generator *Generator) run() {
 	working := 0
 
 	ticks := time.NewTicker(time.Second)
+	defer ticks.Stop()
 	count := 0
 	total := batch{}
 
+	// ctxDone is swapped to nil once ctx fires, so the select's drain-only phase blocks purely
+	// on results/ticks.C. A fired ctx.Done() is permanently ready, so leaving it in the select
+	// would make select spin back into this loop on every iteration instead of blocking,
+	// burning CPU for however long the last in-flight batches take to drain.
+	ctxDone := ctx.Done()
+
 	smoothing := generator.SmoothingFactor
 	if smoothing == 0 {
 		smoothing = DefaultGeneratorSmoothingFactor
 	}
 
 	for {
-		// start as many concurrent batches as it is required
-		for working < batches {
+		// once told to stop, let in-flight batches drain instead of starting new ones
+		for working < batches && ctx.Err() == nil {
 			working++
 			queue := pool.Get().(chan batch)
 			queue <- batch{
@@ -165,6 +333,14 @@ func (generator *Generator) run() {
 			}
 		}
 
+		if ctx.Err() != nil {
+			if working == 0 {
+				stop(Snapshot{QPS: generator.getQPS(), R: r, B: batches, N: n, W: w})
+				return
+			}
+			ctxDone = nil
+		}
+
 		select {
 		case item := <-results:
 			count++
@@ -174,6 +350,15 @@ func (generator *Generator) run() {
 			working--
 			pool.Put(item.passback)
 
+			if generator.Metrics != nil {
+				generator.Metrics.Counter("generator.requests").Inc(int64(item.requests))
+				generator.Metrics.Meter("generator.qps").Mark(int64(item.requests))
+				if item.requests > 0 {
+					latency := item.handlerTime / time.Duration(item.requests)
+					generator.Metrics.Histogram("generator.latency").Update(latency.Nanoseconds())
+				}
+			}
+
 		case <-ticks.C:
 			if count == 0 {
 				break
@@ -183,9 +368,19 @@ func (generator *Generator) run() {
 			r = m*r + (1.0-m)*(total.duration.Seconds()/float64(total.requests)-w)
 			batches, n, w = generator.evaluate(r)
 
+			if generator.Metrics != nil {
+				generator.Metrics.Gauge("generator.B").Update(float64(batches))
+				generator.Metrics.Gauge("generator.N").Update(float64(n))
+				generator.Metrics.Gauge("generator.W").Update(w)
+				generator.Metrics.Gauge("generator.pool_depth").Update(float64(working))
+			}
+
 			count = 0
 			total.requests = 0
 			total.duration = 0
+
+		case <-ctxDone:
+			// loop back around; the check above drains remaining batches before stopping
 		}
 	}
 }
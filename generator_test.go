@@ -1,8 +1,9 @@
 // Copyright (c) 2014 Datacratic. All rights reserved.
 
-package gopid
+package control
 
 import (
+	"context"
 	"math"
 	"sync/atomic"
 	"testing"
@@ -32,7 +33,10 @@ func TestGenerator(t *testing.T) {
 	}
 
 	t0 := time.Now()
-	g.Start()
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error starting generator: %v", err)
+	}
+	defer g.Stop()
 	result := <-results
 
 	dt := result.Sub(t0).Seconds()
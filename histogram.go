@@ -0,0 +1,274 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// DefaultReservoirSize contains the default number of samples kept by a Histogram's reservoir.
+var DefaultReservoirSize = 1028
+
+// Sample is a reservoir that keeps a bounded, statistically representative subset
+// of the values it is updated with, following the sampling strategies used by
+// rcrowley/go-metrics.
+type Sample interface {
+	// Update adds v to the sample.
+	Update(v int64)
+	// Values returns the values currently held in the sample.
+	Values() []int64
+	// Clear empties the sample.
+	Clear()
+}
+
+// UniformSample is a Sample that keeps a uniform random subset of all updates
+// using Vitter's Algorithm R, so that every observed value has an equal
+// probability of being retained regardless of when it arrived.
+type UniformSample struct {
+	mu     sync.Mutex
+	size   int
+	count  int64
+	values []int64
+	rand   *rand.Rand
+}
+
+// NewUniformSample returns an empty UniformSample holding up to size values.
+func NewUniformSample(size int) *UniformSample {
+	return &UniformSample{
+		size: size,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Update adds v to the sample.
+func (s *UniformSample) Update(v int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	if len(s.values) < s.size {
+		s.values = append(s.values, v)
+		return
+	}
+	if i := s.rand.Int63n(s.count); i < int64(s.size) {
+		s.values[i] = v
+	}
+}
+
+// Values returns a copy of the values currently held in the sample.
+func (s *UniformSample) Values() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]int64, len(s.values))
+	copy(out, s.values)
+	return out
+}
+
+// Clear empties the sample.
+func (s *UniformSample) Clear() {
+	s.mu.Lock()
+	s.values = nil
+	s.count = 0
+	s.mu.Unlock()
+}
+
+// rescaleThreshold is how often an expDecaySample rescales its priorities and resets its
+// clock, matching rcrowley/go-metrics' ExpDecaySample. Without it, math.Exp(alpha*t) grows
+// unbounded in t and overflows to +Inf after a matter of hours, at which point the eviction
+// check priority > min (itself +Inf) can never succeed again and the reservoir freezes.
+const rescaleThreshold = time.Hour
+
+// expDecaySample implements a forward-decaying reservoir, weighting recent
+// values more heavily than old ones so that percentiles track a rolling
+// window instead of the whole history of the histogram. It follows the
+// algorithm used by rcrowley/go-metrics' ExpDecaySample.
+type expDecaySample struct {
+	mu        sync.Mutex
+	alpha     float64
+	size      int
+	count     int64
+	start     time.Time
+	nextScale time.Time
+	rand      *rand.Rand
+	entries   map[float64]int64
+}
+
+// NewExpDecaySample returns an empty exponentially-decaying Sample holding up
+// to size values, weighted with decay constant alpha (rcrowley/go-metrics
+// uses 0.015 as a sensible default, biasing toward the last five minutes).
+func NewExpDecaySample(size int, alpha float64) Sample {
+	now := time.Now()
+	return &expDecaySample{
+		alpha:     alpha,
+		size:      size,
+		start:     now,
+		nextScale: now.Add(rescaleThreshold),
+		rand:      rand.New(rand.NewSource(now.UnixNano())),
+		entries:   make(map[float64]int64, size),
+	}
+}
+
+func (s *expDecaySample) Update(v int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rescaleIfDue()
+	s.count++
+	t := time.Since(s.start).Seconds()
+	priority := math.Exp(s.alpha*t) / s.rand.Float64()
+	if len(s.entries) < s.size {
+		s.entries[priority] = v
+		return
+	}
+	min := math.Inf(1)
+	for k := range s.entries {
+		if k < min {
+			min = k
+		}
+	}
+	if priority > min {
+		delete(s.entries, min)
+		s.entries[priority] = v
+	}
+}
+
+// rescaleIfDue rescales every stored priority down to what it would be if start were reset to
+// now, and resets nextScale another rescaleThreshold out. Called with mu held. This is what
+// keeps math.Exp(alpha*t) from growing unbounded and overflowing to +Inf on a long-running
+// Histogram.
+func (s *expDecaySample) rescaleIfDue() {
+	now := time.Now()
+	if now.Before(s.nextScale) {
+		return
+	}
+	oldStart := s.start
+	s.start = now
+	s.nextScale = now.Add(rescaleThreshold)
+
+	factor := math.Exp(-s.alpha * now.Sub(oldStart).Seconds())
+	rescaled := make(map[float64]int64, len(s.entries))
+	for priority, v := range s.entries {
+		rescaled[priority*factor] = v
+	}
+	s.entries = rescaled
+}
+
+func (s *expDecaySample) Values() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]int64, 0, len(s.entries))
+	for _, v := range s.entries {
+		out = append(out, v)
+	}
+	return out
+}
+
+func (s *expDecaySample) Clear() {
+	s.mu.Lock()
+	s.entries = make(map[float64]int64, s.size)
+	s.count = 0
+	s.start = time.Now()
+	s.nextScale = s.start.Add(rescaleThreshold)
+	s.mu.Unlock()
+}
+
+// Histogram tracks the statistical distribution of a stream of int64 values
+// using a Sample reservoir, exposing counts, extrema and percentiles the way
+// rcrowley/go-metrics' Histogram does.
+type Histogram struct {
+	mu     sync.Mutex
+	sample Sample
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+// NewHistogram returns a Histogram backed by the given Sample. Use
+// NewUniformSample for a statistically unbiased sample of the whole stream, or
+// NewExpDecaySample for one biased toward recent values.
+func NewHistogram(sample Sample) *Histogram {
+	return &Histogram{sample: sample}
+}
+
+// Update adds v to the histogram.
+func (h *Histogram) Update(v int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+	h.sample.Update(v)
+}
+
+// Count returns the total number of values ever recorded, regardless of what the sample retained.
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Min returns the smallest value ever recorded.
+func (h *Histogram) Min() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.min
+}
+
+// Max returns the largest value ever recorded.
+func (h *Histogram) Max() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+// Mean returns the mean of the values currently held in the sample.
+func (h *Histogram) Mean() float64 {
+	values := h.floatValues()
+	if len(values) == 0 {
+		return 0
+	}
+	return stat.Mean(values, nil)
+}
+
+// Percentile returns the value below which p (in [0, 1]) of the sampled values fall.
+func (h *Histogram) Percentile(p float64) float64 {
+	values := h.floatValues()
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	return stat.Quantile(p, stat.Empirical, values, nil)
+}
+
+// Percentiles returns Percentile(p) for each p in ps.
+func (h *Histogram) Percentiles(ps []float64) []float64 {
+	values := h.floatValues()
+	out := make([]float64, len(ps))
+	if len(values) == 0 {
+		return out
+	}
+	sort.Float64s(values)
+	for i, p := range ps {
+		out[i] = stat.Quantile(p, stat.Empirical, values, nil)
+	}
+	return out
+}
+
+func (h *Histogram) floatValues() []float64 {
+	raw := h.sample.Values()
+	values := make([]float64, len(raw))
+	for i, v := range raw {
+		values[i] = float64(v)
+	}
+	return values
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import "testing"
+
+// TestHistogramPercentiles checks Percentile against a known uniform distribution [1, 100],
+// backed by a UniformSample large enough to retain every value.
+func TestHistogramPercentiles(t *testing.T) {
+	h := NewHistogram(NewUniformSample(1000))
+	for i := int64(1); i <= 100; i++ {
+		h.Update(i)
+	}
+
+	if got := h.Count(); got != 100 {
+		t.Fatalf("expected count 100, got %d", got)
+	}
+	if got := h.Min(); got != 1 {
+		t.Fatalf("expected min 1, got %d", got)
+	}
+	if got := h.Max(); got != 100 {
+		t.Fatalf("expected max 100, got %d", got)
+	}
+	if got := h.Mean(); got < 50 || got > 51 {
+		t.Fatalf("expected mean close to 50.5, got %f", got)
+	}
+
+	if got := h.Percentile(0.5); got < 49 || got > 52 {
+		t.Fatalf("expected p50 close to 50, got %f", got)
+	}
+	if got := h.Percentile(0.99); got < 97 {
+		t.Fatalf("expected p99 close to 99, got %f", got)
+	}
+}
+
+func TestUniformSampleBoundedSize(t *testing.T) {
+	s := NewUniformSample(10)
+	for i := int64(0); i < 1000; i++ {
+		s.Update(i)
+	}
+
+	if got := len(s.Values()); got != 10 {
+		t.Fatalf("expected the sample to retain exactly 10 values, got %d", got)
+	}
+}
+
+// TestExpDecaySampleRescales checks that an expDecaySample due for a rescale doesn't leave
+// priorities growing without bound: forcing nextScale into the past and updating once should
+// rescale existing entries down and accept the new sample without panicking or discarding the
+// whole reservoir.
+func TestExpDecaySampleRescales(t *testing.T) {
+	sample := NewExpDecaySample(100, 0.015).(*expDecaySample)
+	sample.Update(1)
+	sample.Update(2)
+
+	sample.mu.Lock()
+	sample.nextScale = sample.start
+	sample.mu.Unlock()
+
+	sample.Update(3)
+
+	if got := len(sample.Values()); got != 3 {
+		t.Fatalf("expected all 3 updates to survive a rescale, got %d values", got)
+	}
+}
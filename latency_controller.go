@@ -0,0 +1,130 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyControllerWindow contains the default interval at which a LatencyController samples its aggregate and steps its PID.
+var DefaultLatencyControllerWindow = 10 * time.Second
+
+// DefaultLatencyControllerPercentile contains the default percentile sampled when Aggregator is unset.
+var DefaultLatencyControllerPercentile = 0.99
+
+// Aggregator reduces a rolling latency Histogram to the scalar fed into a LatencyController's PID.
+type Aggregator func(histogram *Histogram) float64
+
+// PercentileAggregator returns an Aggregator that reports histogram.Percentile(p).
+func PercentileAggregator(p float64) Aggregator {
+	return func(h *Histogram) float64 { return h.Percentile(p) }
+}
+
+// MeanAggregator reports the mean latency observed in the window.
+func MeanAggregator(h *Histogram) float64 { return h.Mean() }
+
+// MaxAggregator reports the maximum latency observed in the window.
+func MaxAggregator(h *Histogram) float64 {
+	max := 0.0
+	for _, v := range h.floatValues() {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// LatencyController closes a PID loop around a latency aggregate (by default P99 handler
+// latency), driving Generator.QPS up or down to hold the aggregate at Target. It lets users
+// express "run as fast as possible while keeping P99 under 50ms" instead of the mean-only
+// rate control PID.Step provides on its own.
+type LatencyController struct {
+	// Generator is the load generator whose QPS is the manipulated variable. Run wraps its
+	// Handler to time every call; the wrapped Handler is restored when ctx is done.
+	Generator *Generator
+	// Target is the latency, in seconds, the controller tries to hold the aggregate at.
+	Target float64
+	// Percentile is the percentile sampled every tick when Aggregator is nil. Uses DefaultLatencyControllerPercentile if 0.
+	Percentile float64
+	// Window is how often the aggregate is sampled and fed into the PID. Uses DefaultLatencyControllerWindow if 0.
+	Window time.Duration
+	// MinQPS and MaxQPS clamp the QPS written back to Generator.QPS.
+	MinQPS, MaxQPS float64
+	// Aggregator reduces the rolling Histogram to a scalar every tick. Defaults to PercentileAggregator(Percentile).
+	Aggregator Aggregator
+	// PID is the embedded controller driving QPS from the latency aggregate. Tune its gains
+	// (e.g. via AutoTune) before calling Run; Target, MinOutput and MaxOutput are set by Run.
+	PID PID
+
+	mu        sync.Mutex
+	histogram *Histogram
+}
+
+// Observe records a single handler latency sample into the controller's rolling histogram.
+// Run calls this automatically for every request; call it directly only if you are driving
+// the histogram yourself instead of going through Run.
+func (c *LatencyController) Observe(latency time.Duration) {
+	c.mu.Lock()
+	if c.histogram == nil {
+		c.histogram = NewHistogram(NewExpDecaySample(DefaultReservoirSize, 0.015))
+	}
+	h := c.histogram
+	c.mu.Unlock()
+	h.Update(latency.Nanoseconds())
+}
+
+// Run wraps Generator.Handler to time every call, then samples the latency aggregate every
+// Window, steps the PID and writes the clamped output back to Generator.QPS, until ctx is
+// done. It restores the original Handler before returning.
+func (c *LatencyController) Run(ctx context.Context) {
+	window := c.Window
+	if window == 0 {
+		window = DefaultLatencyControllerWindow
+	}
+	percentile := c.Percentile
+	if percentile == 0 {
+		percentile = DefaultLatencyControllerPercentile
+	}
+	aggregate := c.Aggregator
+	if aggregate == nil {
+		aggregate = PercentileAggregator(percentile)
+	}
+
+	original := c.Generator.getHandler()
+	c.Generator.SetHandler(TaskFunc(func() {
+		t0 := time.Now()
+		original.Do()
+		c.Observe(time.Since(t0))
+	}))
+	defer c.Generator.SetHandler(original)
+
+	c.PID.Target = c.Target
+	c.PID.MinOutput = c.MinQPS
+	c.PID.MaxOutput = c.MaxQPS
+	if c.PID.Output == 0 {
+		c.PID.Output = c.Generator.getQPS()
+	}
+
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			h := c.histogram
+			c.mu.Unlock()
+			if h == nil || h.Count() == 0 {
+				continue
+			}
+
+			measured := aggregate(h) / float64(time.Second)
+			qps := c.PID.Update(measured)
+			c.Generator.SetQPS(qps)
+		}
+	}
+}
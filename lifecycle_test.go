@@ -0,0 +1,53 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGeneratorStop(t *testing.T) {
+	g := Generator{
+		QPS:     50,
+		Handler: TaskFunc(func() { time.Sleep(time.Millisecond) }),
+	}
+
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error starting generator: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case <-g.Stop():
+	case <-time.After(2 * time.Second):
+		t.Fatal("generator did not drain within 2 seconds of Stop")
+	}
+
+	snapshot := g.Wait()
+	if snapshot.QPS != g.QPS {
+		t.Fatalf("expected snapshot QPS %f, got %f", g.QPS, snapshot.QPS)
+	}
+}
+
+func TestGeneratorStartRequiresHandler(t *testing.T) {
+	g := Generator{QPS: 1}
+	if err := g.Start(context.Background()); err != ErrGeneratorNoHandler {
+		t.Fatalf("expected ErrGeneratorNoHandler, got %v", err)
+	}
+}
+
+func TestGeneratorStartRespectsCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	g := Generator{
+		QPS:     1,
+		Handler: TaskFunc(func() {}),
+	}
+	if err := g.Start(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"sort"
+	"sync"
+)
+
+// Counter is a monotonic count of events, such as the number of requests served.
+type Counter struct {
+	mu    sync.Mutex
+	count int64
+}
+
+// Inc adds delta to the counter.
+func (c *Counter) Inc(delta int64) {
+	c.mu.Lock()
+	c.count += delta
+	c.mu.Unlock()
+}
+
+// Count returns the current value of the counter.
+func (c *Counter) Count() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count
+}
+
+// Gauge holds the latest value of a quantity that can go up or down, such as the number of pending batches.
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Update sets the gauge to v.
+func (g *Gauge) Update(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// Registry collects the named metrics published by Generator and PID so that
+// Reporters can export them, following the pattern used by rcrowley/go-metrics.
+type Registry struct {
+	mu    sync.RWMutex
+	items map[string]interface{}
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{items: make(map[string]interface{})}
+}
+
+func (r *Registry) get(name string, create func() interface{}) interface{} {
+	r.mu.RLock()
+	item, ok := r.items[name]
+	r.mu.RUnlock()
+	if ok {
+		return item
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if item, ok = r.items[name]; ok {
+		return item
+	}
+	item = create()
+	r.items[name] = item
+	return item
+}
+
+// Counter returns the named Counter, creating it if necessary.
+func (r *Registry) Counter(name string) *Counter {
+	return r.get(name, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+// Gauge returns the named Gauge, creating it if necessary.
+func (r *Registry) Gauge(name string) *Gauge {
+	return r.get(name, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+// Histogram returns the named Histogram, creating it with a DefaultReservoirSize uniform sample if necessary.
+func (r *Registry) Histogram(name string) *Histogram {
+	return r.get(name, func() interface{} { return NewHistogram(NewUniformSample(DefaultReservoirSize)) }).(*Histogram)
+}
+
+// Meter returns the named Meter, creating it if necessary.
+func (r *Registry) Meter(name string) *Meter {
+	return r.get(name, func() interface{} { return NewMeter() }).(*Meter)
+}
+
+// Each calls f for every metric currently in the registry, in name order, so that reporters produce stable output.
+func (r *Registry) Each(f func(name string, metric interface{})) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.items))
+	for name := range r.items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	items := make([]interface{}, len(names))
+	for i, name := range names {
+		items[i] = r.items[name]
+	}
+	r.mu.RUnlock()
+
+	for i, name := range names {
+		f(name, items[i])
+	}
+}
+
+// Close stops every Meter the registry holds, releasing the background goroutine each one
+// started to decay its EWMAs. Call it once the registry's owner (a Generator, a
+// ShardedGenerator shard, ...) has stopped and will no longer update its metrics.
+func (r *Registry) Close() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, item := range r.items {
+		if m, ok := item.(*Meter); ok {
+			m.Stop()
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import "testing"
+
+func TestCounterRoundTrip(t *testing.T) {
+	var c Counter
+	c.Inc(3)
+	c.Inc(4)
+
+	if got := c.Count(); got != 7 {
+		t.Fatalf("expected count 7, got %d", got)
+	}
+}
+
+func TestGaugeRoundTrip(t *testing.T) {
+	var g Gauge
+	g.Update(1.5)
+	g.Update(2.5)
+
+	if got := g.Value(); got != 2.5 {
+		t.Fatalf("expected value 2.5, got %f", got)
+	}
+}
+
+func TestRegistryEachOrder(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("z")
+	r.Counter("a")
+	r.Counter("m")
+
+	var names []string
+	r.Each(func(name string, metric interface{}) {
+		names = append(names, name)
+	})
+
+	want := []string{"a", "m", "z"}
+	if len(names) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+	}
+}
+
+func TestRegistryGetIsIdempotent(t *testing.T) {
+	r := NewRegistry()
+	if r.Counter("requests") != r.Counter("requests") {
+		t.Fatal("expected repeated Counter calls for the same name to return the same instance")
+	}
+}
+
+func TestRegistryCloseStopsMeters(t *testing.T) {
+	r := NewRegistry()
+	m := r.Meter("generator.qps")
+	m.Mark(1)
+
+	r.Close()
+
+	select {
+	case <-m.done:
+	default:
+		t.Fatal("expected Registry.Close to stop the registry's Meters")
+	}
+
+	// Stop is idempotent, so closing twice (once via Registry.Close, once directly) must not panic.
+	m.Stop()
+}
@@ -11,6 +11,12 @@ import (
 	"gonum.org/v1/gonum/stat"
 )
 
+// DefaultPIDFilterN contains the default ratio used to derive Tf from Kd and Kp when Tf is 0.
+var DefaultPIDFilterN = 10.0
+
+// DefaultPIDAntiWindupTt contains the default back-calculation tracking time constant used when Tt is 0.
+var DefaultPIDAntiWindupTt = 1.0
+
 // PID defines a generic PID controller.
 type PID struct {
 	// Kp contains the proportional gain.
@@ -29,12 +35,25 @@ type PID struct {
 	MeanOutput float64
 	MeanLength int
 
-	delta       float64
-	integral    float64
-	timestamp   time.Time
-	prev_target float64
-	prev_delta  float64
-	outputs     []float64
+	// Tf contains the time constant of the first-order low-pass filter applied to the
+	// derivative term, which tempers the noise amplification Kd would otherwise introduce.
+	// If 0, it defaults to Kd/(DefaultPIDFilterN*Kp).
+	Tf float64
+	// Tt contains the back-calculation tracking time constant used to unwind the integral
+	// when Output saturates, instead of simply freezing it. Defaults to DefaultPIDAntiWindupTt if 0.
+	Tt float64
+
+	// Metrics, if set, receives delta, integral, derivative, Output and Target on every Step.
+	Metrics *Registry
+
+	delta              float64
+	integral           float64
+	timestamp          time.Time
+	prev_target        float64
+	prevValue          float64
+	havePrevValue      bool
+	filteredDerivative float64
+	outputs            []float64
 }
 
 // Update performs an iteration of the PID controller with a variable dt based on the system time.
@@ -51,31 +70,69 @@ func (c *PID) Update(value float64) (output float64) {
 	return
 }
 
+// SetGains updates Kp, Ki and Kd and performs bumpless transfer: it backs the stored integral
+// into the value that keeps Output unchanged under the new gains, given the last delta and
+// filtered derivative. This lets callers retune gains live (e.g. from AutoTune) without Output
+// jumping on the next Step.
+func (c *PID) SetGains(Kp, Ki, Kd float64) {
+	if Ki != 0 {
+		c.integral = (c.Output - Kp*c.delta - Kd*c.filteredDerivative) / Ki
+	}
+	c.Kp, c.Ki, c.Kd = Kp, Ki, Kd
+}
+
 // Step performs an iteration of the PID controller.
 func (c *PID) Step(value float64, dt time.Duration) (output float64) {
 	if dt == 0 {
 		output = c.Output
 		return
 	}
-	delta := c.Target - value
 	step := dt.Seconds()
+	delta := c.Target - value
+
 	if c.Target != c.prev_target {
 		// integral is no longer valid if target has changed
 		c.integral = 0
 	}
 	c.prev_target = c.Target
-	if (delta < 0 && c.Output > c.MinOutput) || (delta > 0 && c.Output < c.MaxOutput) {
-		// We only increase the integral if we're not already pegged
-		// at min or max output. If we're properly tuned but pegged,
-		// then our system is underpowered. In that case, it doesn't
-		// do any good to continue to accumulate the integral; that
-		// will just cause overshoot.
-		c.integral += delta * step
+
+	// derivative on measurement rather than on error, so a step change in Target doesn't
+	// produce the "derivative kick" a naive (delta-c.delta)/step term would.
+	var rawDerivative float64
+	if c.havePrevValue {
+		rawDerivative = -(value - c.prevValue) / step
+	}
+	c.prevValue = value
+	c.havePrevValue = true
+
+	tf := c.Tf
+	if tf == 0 && c.Kp != 0 {
+		tf = c.Kd / (DefaultPIDFilterN * c.Kp)
+	}
+	if tf > 0 {
+		alpha := step / (tf + step)
+		c.filteredDerivative += alpha * (rawDerivative - c.filteredDerivative)
+	} else {
+		c.filteredDerivative = rawDerivative
+	}
+	derivative := c.filteredDerivative
+
+	c.integral += delta * step
+
+	raw := c.Kp*delta + c.Ki*c.integral + c.Kd*derivative
+	output = math.Min(c.MaxOutput, math.Max(c.MinOutput, raw))
+
+	if raw != output {
+		// back-calculation anti-windup: unwind the integral by the saturation error instead
+		// of freezing it, so the controller recovers promptly once it's no longer pegged.
+		tt := c.Tt
+		if tt == 0 {
+			tt = DefaultPIDAntiWindupTt
+		}
+		c.integral -= (raw - output) / tt * step
 	}
-	derivative := (delta - c.delta) / step
+
 	c.delta = delta
-	output = c.Kp*delta + c.Ki*c.integral + c.Kd*derivative
-	output = math.Min(c.MaxOutput, math.Max(c.MinOutput, output))
 	c.Output = output
 	// create MeanOutput XXX move all this to PIDHistory
 	c.outputs = append(c.outputs, output)
@@ -83,7 +140,13 @@ func (c *PID) Step(value float64, dt time.Duration) (output float64) {
 		c.outputs = c.outputs[len(c.outputs)-c.MeanLength:]
 	}
 	c.MeanOutput = stat.Mean(c.outputs, nil)
-	fmt.Printf("pid input %.2f target %.2f output %.2f mean %.2f kp %.4f ki %.6f kd %.4f delta %.3f step %.3f integral %.3f derivative %.3f\n", value, c.Target, output, c.MeanOutput, c.Kp, c.Ki, c.Kd, delta, step, c.integral, derivative)
+	if c.Metrics != nil {
+		c.Metrics.Gauge("pid.delta").Update(delta)
+		c.Metrics.Gauge("pid.integral").Update(c.integral)
+		c.Metrics.Gauge("pid.derivative").Update(derivative)
+		c.Metrics.Gauge("pid.output").Update(output)
+		c.Metrics.Gauge("pid.setpoint").Update(c.Target)
+	}
 	return
 }
 
@@ -0,0 +1,41 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPIDSetGainsBumpless(t *testing.T) {
+	c := PID{Kp: 1, Ki: 0.5, Kd: 0, Target: 10, MinOutput: -100, MaxOutput: 100}
+
+	before := c.Step(8, time.Second)
+
+	Kp, Ki, Kd := 2.0, 0.2, 0.0
+	c.SetGains(Kp, Ki, Kd)
+
+	// SetGains only rewrites the integral; recomputing the PID formula with the new gains
+	// and the stored integral/delta/derivative should reproduce the pre-transfer output.
+	after := Kp*c.delta + Ki*c.integral + Kd*c.filteredDerivative
+
+	if math.Abs(before-after) > 1e-9 {
+		t.Fatalf("expected bumpless output of %f after SetGains, got %f", before, after)
+	}
+}
+
+func TestPIDDerivativeOnMeasurementNoKick(t *testing.T) {
+	c := PID{Kp: 0, Ki: 0, Kd: 1, MinOutput: -1000, MaxOutput: 1000}
+
+	c.Target = 0
+	c.Step(5, time.Second)
+
+	// a target change alone, with measurement unchanged, must not move the derivative term.
+	c.Target = 100
+	output := c.Step(5, time.Second)
+
+	if math.Abs(output) > 1e-9 {
+		t.Fatalf("expected no derivative kick from a Target change, got output %f", output)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Reporter periodically exports the content of a Registry to some destination (logs, expvar, Prometheus, ...).
+type Reporter interface {
+	// Report is called once per interval with the Registry to export.
+	Report(r *Registry)
+}
+
+// DefaultReporterInterval contains the default interval at which a LogReporter or ExpvarReporter refreshes its output.
+var DefaultReporterInterval = 10 * time.Second
+
+// Run calls reporter.Report(registry) every interval until ctx is done.
+func Run(ctx context.Context, registry *Registry, reporter Reporter, interval time.Duration) {
+	if interval == 0 {
+		interval = DefaultReporterInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reporter.Report(registry)
+		}
+	}
+}
+
+// LogReporter writes a human-readable line per metric to Logger (or the standard logger if nil) on every Report.
+type LogReporter struct {
+	// Logger receives one line per metric. Uses the standard library's default logger if nil.
+	Logger *log.Logger
+}
+
+// Report writes the current value of every metric in r to the LogReporter's Logger.
+func (rep *LogReporter) Report(r *Registry) {
+	logger := rep.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	r.Each(func(name string, metric interface{}) {
+		switch m := metric.(type) {
+		case *Counter:
+			logger.Printf("%s count=%d", name, m.Count())
+		case *Gauge:
+			logger.Printf("%s value=%.4f", name, m.Value())
+		case *Histogram:
+			ps := m.Percentiles([]float64{0.5, 0.9, 0.99})
+			logger.Printf("%s count=%d min=%d max=%d mean=%.4f p50=%.4f p90=%.4f p99=%.4f", name, m.Count(), m.Min(), m.Max(), m.Mean(), ps[0], ps[1], ps[2])
+		case *Meter:
+			logger.Printf("%s count=%d rate1=%.4f rate5=%.4f rate15=%.4f mean=%.4f", name, m.Count(), m.Rate1(), m.Rate5(), m.Rate15(), m.RateMean())
+		}
+	})
+}
+
+// ExpvarReporter mirrors every metric in a Registry into a process-wide expvar.Map published under
+// the namespace given to NewExpvarReporter, so metrics show up on the standard /debug/vars endpoint.
+type ExpvarReporter struct {
+	vars *expvar.Map
+}
+
+// NewExpvarReporter returns an ExpvarReporter that publishes under the given expvar namespace.
+func NewExpvarReporter(namespace string) *ExpvarReporter {
+	return &ExpvarReporter{vars: expvar.NewMap(namespace)}
+}
+
+// Report refreshes the expvar.Map with the current value of every metric in r.
+func (rep *ExpvarReporter) Report(r *Registry) {
+	r.Each(func(name string, metric interface{}) {
+		switch m := metric.(type) {
+		case *Counter:
+			rep.vars.Set(name, expvarInt(m.Count()))
+		case *Gauge:
+			rep.vars.Set(name, expvarFloat(m.Value()))
+		case *Histogram:
+			rep.vars.Set(name+".p99", expvarFloat(m.Percentile(0.99)))
+		case *Meter:
+			rep.vars.Set(name+".rate1", expvarFloat(m.Rate1()))
+		}
+	})
+}
+
+type expvarInt int64
+
+func (v expvarInt) String() string { return fmt.Sprintf("%d", int64(v)) }
+
+type expvarFloat float64
+
+func (v expvarFloat) String() string { return fmt.Sprintf("%f", float64(v)) }
+
+// PrometheusReporter adapts a Registry to the prometheus.Collector interface so its metrics can be
+// scraped via promhttp.Handler.
+type PrometheusReporter struct {
+	registry *Registry
+	prom     *prometheus.Registry
+}
+
+// NewPrometheusReporter returns a PrometheusReporter exposing the metrics in r.
+func NewPrometheusReporter(r *Registry) *PrometheusReporter {
+	rep := &PrometheusReporter{registry: r, prom: prometheus.NewRegistry()}
+	rep.prom.MustRegister(rep)
+	return rep
+}
+
+// Report is a no-op for PrometheusReporter: metrics are pulled on scrape via Collect rather than pushed.
+func (rep *PrometheusReporter) Report(r *Registry) {}
+
+// Describe implements prometheus.Collector. Metrics are registered dynamically, so it emits nothing.
+func (rep *PrometheusReporter) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector, translating every metric in the Registry into a constant Prometheus metric.
+// Characters not allowed in a Prometheus metric name, such as the dots in "generator.qps", become underscores.
+func (rep *PrometheusReporter) Collect(ch chan<- prometheus.Metric) {
+	rep.registry.Each(func(name string, metric interface{}) {
+		name = strings.Map(func(c rune) rune {
+			if c == '_' || c == ':' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+				return c
+			}
+			return '_'
+		}, name)
+		switch m := metric.(type) {
+		case *Counter:
+			desc := prometheus.NewDesc(name, name, nil, nil)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(m.Count()))
+		case *Gauge:
+			desc := prometheus.NewDesc(name, name, nil, nil)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, m.Value())
+		case *Histogram:
+			desc := prometheus.NewDesc(name, name, nil, nil)
+			ch <- prometheus.MustNewConstSummary(desc, uint64(m.Count()), float64(m.Count())*m.Mean(), map[float64]float64{
+				0.5:  m.Percentile(0.5),
+				0.9:  m.Percentile(0.9),
+				0.99: m.Percentile(0.99),
+			})
+		case *Meter:
+			desc := prometheus.NewDesc(name, name, nil, nil)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, m.Rate1())
+		}
+	})
+}
+
+// Handler returns the http.Handler that serves rep's metrics in the Prometheus exposition format.
+func (rep *PrometheusReporter) Handler() http.Handler {
+	return promhttp.HandlerFor(rep.prom, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogReporterReportsEveryMetricKind(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests").Inc(5)
+	r.Gauge("pool_depth").Update(3)
+	r.Histogram("latency").Update(10)
+	m := r.Meter("qps")
+	defer m.Stop()
+	m.Mark(1)
+
+	var buf bytes.Buffer
+	rep := &LogReporter{Logger: log.New(&buf, "", 0)}
+	rep.Report(r)
+
+	out := buf.String()
+	for _, want := range []string{"requests count=5", "pool_depth value=3", "latency count=1", "qps count=1"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected log output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestExpvarReporterPublishesMetrics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests").Inc(5)
+
+	rep := NewExpvarReporter("gopid_test_reporter")
+	rep.Report(r)
+
+	if got := rep.vars.Get("requests").String(); got != "5" {
+		t.Fatalf("expected expvar requests to read 5, got %s", got)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// ShardStats is a point-in-time, lock-free snapshot of a single shard's measurements.
+type ShardStats struct {
+	// QPS contains the shard's own achieved rate, as tracked by its Meter.
+	QPS float64
+	// R contains the shard's estimate of the time taken by the handler, in seconds.
+	R float64
+}
+
+// ShardedGenerator partitions a target QPS across Shards independent Generators, each with its
+// own batch pool, ticker and R estimator, instead of serializing every batch completion through
+// one results channel and one run goroutine the way a single Generator does. This follows the
+// same idea as Go's per-P timer sharding: at high QPS, the single shared channel becomes the
+// bottleneck well before the handler itself does.
+type ShardedGenerator struct {
+	// Handler contains what gets executed periodically. Shared by every shard's Generator.
+	Handler Task
+	// QPS contains the total number of calls that will be done per second, split evenly across Shards.
+	QPS float64
+	// Shards contains the number of independent sub-generators to run. Uses runtime.GOMAXPROCS(0) if 0.
+	Shards int
+	// SmoothingFactor, SleepPrecision, SamplingPeriod and Interarrival are copied as-is to every shard's Generator.
+	SmoothingFactor float64
+	SleepPrecision  time.Duration
+	SamplingPeriod  time.Duration
+	Interarrival    Distribution
+	// Metrics, if set, additionally receives a per-shard "shard.<i>.qps" and "shard.<i>.r" gauge every second.
+	Metrics *Registry
+
+	generators []*Generator
+	stats      []atomic.Value
+}
+
+func (sg *ShardedGenerator) shardCount() int {
+	if sg.Shards > 0 {
+		return sg.Shards
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Start partitions QPS evenly across the shards and starts a Generator for each. If any shard
+// fails to start, Start stops the shards already started and returns that error.
+func (sg *ShardedGenerator) Start(ctx context.Context) error {
+	if sg.Handler == nil {
+		return ErrGeneratorNoHandler
+	}
+
+	n := sg.shardCount()
+	sg.generators = make([]*Generator, n)
+	sg.stats = make([]atomic.Value, n)
+
+	perShard := sg.QPS / float64(n)
+
+	for i := 0; i < n; i++ {
+		g := &Generator{
+			Handler:         sg.Handler,
+			QPS:             perShard,
+			SmoothingFactor: sg.SmoothingFactor,
+			SleepPrecision:  sg.SleepPrecision,
+			SamplingPeriod:  sg.SamplingPeriod,
+			Interarrival:    sg.Interarrival,
+			Metrics:         NewRegistry(),
+		}
+
+		if err := g.Start(ctx); err != nil {
+			for _, started := range sg.generators[:i] {
+				<-started.Stop()
+			}
+			return err
+		}
+
+		sg.generators[i] = g
+		go sg.watch(ctx, i, g)
+	}
+
+	return nil
+}
+
+// watch polls shard i's private Metrics registry once a second and stores the result as a
+// lock-free atomic snapshot, rather than funneling every shard's measurements through a shared channel.
+func (sg *ShardedGenerator) watch(ctx context.Context, i int, g *Generator) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			meter := g.Metrics.Meter("generator.qps")
+			latency := g.Metrics.Histogram("generator.latency")
+
+			s := ShardStats{
+				QPS: meter.Rate1(),
+				R:   latency.Mean() / float64(time.Second),
+			}
+			sg.stats[i].Store(s)
+
+			if sg.Metrics != nil {
+				sg.Metrics.Gauge(fmt.Sprintf("shard.%d.qps", i)).Update(s.QPS)
+				sg.Metrics.Gauge(fmt.Sprintf("shard.%d.r", i)).Update(s.R)
+			}
+		}
+	}
+}
+
+// Stop cancels every shard and returns a channel that is closed once all of them have drained.
+func (sg *ShardedGenerator) Stop() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for _, g := range sg.generators {
+			<-g.Stop()
+		}
+		close(done)
+	}()
+	return done
+}
+
+// AggregateStats returns the total observed QPS summed across shards, and the variance of their
+// individual R (handler latency) estimates, which should stay low when load is evenly shared.
+func (sg *ShardedGenerator) AggregateStats() (totalQPS float64, rVariance float64) {
+	rs := make([]float64, 0, len(sg.stats))
+	for i := range sg.stats {
+		s, ok := sg.stats[i].Load().(ShardStats)
+		if !ok {
+			continue
+		}
+		totalQPS += s.QPS
+		rs = append(rs, s.R)
+	}
+	if len(rs) > 1 {
+		rVariance = stat.Variance(rs, nil)
+	}
+	return
+}
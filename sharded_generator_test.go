@@ -0,0 +1,74 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package control
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchmarkWindow is how long each benchmark drives its Generator before measuring throughput;
+// these benchmarks are wall-clock bound rather than CPU-loop bound, so they ignore b.N.
+const benchmarkWindow = 2 * time.Second
+
+// noopHandler simulates a sub-microsecond payload: the case where a single Generator's shared
+// results channel and run goroutine, rather than the handler, become the bottleneck.
+func noopHandler(count *int64) Task {
+	return TaskFunc(func() {
+		atomic.AddInt64(count, 1)
+	})
+}
+
+// BenchmarkGenerator measures the throughput of a single, unsharded Generator.
+func BenchmarkGenerator(b *testing.B) {
+	var count int64
+
+	g := Generator{
+		QPS:     1e7,
+		Handler: noopHandler(&count),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := g.Start(ctx); err != nil {
+		b.Fatalf("unexpected error starting generator: %v", err)
+	}
+
+	atomic.StoreInt64(&count, 0)
+	b.ResetTimer()
+	time.Sleep(benchmarkWindow)
+	b.StopTimer()
+
+	cancel()
+	<-g.Stop()
+
+	b.ReportMetric(float64(atomic.LoadInt64(&count))/benchmarkWindow.Seconds(), "req/s")
+}
+
+// BenchmarkShardedGenerator measures the throughput of a ShardedGenerator driving the same
+// sub-microsecond payload, and is expected to clear BenchmarkGenerator's throughput by more
+// than 5x on an 8-core machine, since the bottleneck there is the single shared results channel.
+func BenchmarkShardedGenerator(b *testing.B) {
+	var count int64
+
+	sg := ShardedGenerator{
+		QPS:     1e7,
+		Handler: noopHandler(&count),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := sg.Start(ctx); err != nil {
+		b.Fatalf("unexpected error starting sharded generator: %v", err)
+	}
+
+	atomic.StoreInt64(&count, 0)
+	b.ResetTimer()
+	time.Sleep(benchmarkWindow)
+	b.StopTimer()
+
+	cancel()
+	<-sg.Stop()
+
+	b.ReportMetric(float64(atomic.LoadInt64(&count))/benchmarkWindow.Seconds(), "req/s")
+}